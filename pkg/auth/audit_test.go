@@ -0,0 +1,82 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adobe/cluster-registry/pkg/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewLogAuditSink(&buf)
+
+	sink.Audit(AuditRecord{Subject: "user-1", Decision: decisionDeny, Reason: "expired"})
+
+	var got AuditRecord
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, "user-1", got.Subject)
+	assert.Equal(t, decisionDeny, got.Decision)
+	assert.Equal(t, "expired", got.Reason)
+}
+
+func TestWrapAuthenticateRecordsAudit(t *testing.T) {
+	var buf bytes.Buffer
+	a := &Authenticator{
+		config:     &config.AppConfig{},
+		auditSinks: []AuditSink{NewLogAuditSink(&buf)},
+	}
+
+	mw := a.wrapAuthenticate(func(c echo.Context) error {
+		c.Set(ClientIDContextKey, "test-client")
+		return deny(http.StatusForbidden, "group_denied")
+	})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/v1/clusters", nil)
+	res := httptest.NewRecorder()
+	c := e.NewContext(req, res)
+
+	err := mw(func(echo.Context) error { return nil })(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, c.Response().Status)
+
+	var got AuditRecord
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, decisionDeny, got.Decision)
+	assert.Equal(t, "group_denied", got.Reason)
+	assert.Equal(t, "test-client", got.ClientID)
+}
+
+func TestClassifyVerifyError(t *testing.T) {
+	tcs := []struct {
+		err    error
+		reason string
+	}{
+		{errors.New("oidc: token is expired"), "expired"},
+		{errors.New("oidc: expected audience"), "bad_aud"},
+		{errors.New("oidc: issuer did not match"), "bad_iss"},
+		{errors.New("oidc: signature verification failed"), "sig_invalid"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.reason, classifyVerifyError(tc.err))
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+// Package auth implements OIDC bearer token authentication and group based
+// authorization for the cluster-registry API.
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adobe/cluster-registry/pkg/config"
+	monitoring "github.com/adobe/cluster-registry/pkg/monitoring/apiserver"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	spnPrefix = "spn:"
+
+	defaultGroupsClaim = "groups"
+
+	// claimsContextKey holds the authenticated caller's claims, as set by
+	// VerifyToken or VerifyClientCert, for VerifyGroupAccess, VerifyPermission
+	// and audit records to consume.
+	claimsContextKey = "auth-claims"
+	// IssuerContextKey holds the issuer URL of the OIDC provider that
+	// authenticated the request, or "introspect"/"spiffe" when the request
+	// was authenticated by token introspection or an mTLS client certificate,
+	// for downstream audit logging.
+	IssuerContextKey = "auth-issuer"
+	// ClientIDContextKey holds the client id (or verification-method-specific
+	// equivalent, e.g. a SPIFFE ID) that the request authenticated as, for
+	// downstream audit logging.
+	ClientIDContextKey = "auth-client-id"
+)
+
+// Authenticator verifies OIDC bearer tokens presented to the API and authorizes
+// access to cluster-registry resources based on the claims they carry.
+type Authenticator struct {
+	config  *config.AppConfig
+	metrics *monitoring.Metrics
+
+	providers map[string]*providerAuth
+
+	jwksManagers []*jwksManager
+
+	policy        *PolicyEngine
+	mtls          *mtlsVerifier
+	introspection *IntrospectionVerifier
+
+	// auditSinks receives an AuditRecord for every VerifyToken/
+	// VerifyGroupAccess/VerifyPermission/VerifyClientCert decision. It always
+	// contains a default sink that logs to stderr as structured JSON.
+	auditSinks []AuditSink
+}
+
+// providerAuth bundles together everything needed to verify tokens minted by
+// a single trusted issuer.
+type providerAuth struct {
+	issuer      string
+	groupsClaim string
+	verifiers   []clientVerifier
+}
+
+// clientVerifier pairs an oidc.IDTokenVerifier with the aud/client id it
+// accepts, so a successful verification can report which client the caller
+// authenticated as.
+type clientVerifier struct {
+	clientID string
+	verifier *oidc.IDTokenVerifier
+}
+
+// claims is the subset of the JWT payload the authenticator cares about.
+type claims struct {
+	Groups []string
+	Roles  []string
+	OID    string
+	Tenant string
+	// Raw holds every claim from the token, for policies keying off custom
+	// claims the authenticator doesn't otherwise surface.
+	Raw map[string]interface{}
+}
+
+// NewAuthenticator builds an Authenticator for appConfig and starts each
+// trusted provider's background JWKS refresh loop. Discovering a provider's
+// OIDC metadata and JWKS endpoint requires a live HTTP call, so it happens
+// lazily on first token verification rather than here.
+func NewAuthenticator(appConfig *config.AppConfig, metrics *monitoring.Metrics) (*Authenticator, error) {
+	httpClient, err := newOidcHTTPClient(appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		config:     appConfig,
+		metrics:    metrics,
+		providers:  map[string]*providerAuth{},
+		auditSinks: []AuditSink{NewLogAuditSink(os.Stderr)},
+	}
+
+	if appConfig.AuditWebhookURL != "" {
+		a.auditSinks = append(a.auditSinks, NewWebhookAuditSink(appConfig.AuditWebhookURL, httpClient))
+	}
+
+	for _, providerConfig := range oidcProviderConfigs(appConfig) {
+		jwks := newJwksManager(httpClient, providerConfig.IssuerUrl, providerConfig.JwksUrl, appConfig)
+		jwks.startBackgroundRefresh()
+		a.jwksManagers = append(a.jwksManagers, jwks)
+
+		groupsClaim := providerConfig.GroupsClaim
+		if groupsClaim == "" {
+			groupsClaim = defaultGroupsClaim
+		}
+
+		pa := &providerAuth{issuer: providerConfig.IssuerUrl, groupsClaim: groupsClaim}
+		for _, clientID := range providerConfig.ClientIds {
+			pa.verifiers = append(pa.verifiers, clientVerifier{
+				clientID: clientID,
+				verifier: oidc.NewVerifier(providerConfig.IssuerUrl, jwks.keySet, &oidc.Config{ClientID: clientID}),
+			})
+		}
+
+		a.providers[providerConfig.IssuerUrl] = pa
+	}
+
+	if appConfig.AuthzPolicyFile != "" {
+		policy, err := NewPolicyEngine(appConfig.AuthzPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: failed to load authorization policy: %w", err)
+		}
+
+		reloadInterval := appConfig.AuthzPolicyReloadInterval
+		if reloadInterval <= 0 {
+			reloadInterval = 30 * time.Second
+		}
+		policy.StartHotReload(reloadInterval)
+
+		a.policy = policy
+	}
+
+	if appConfig.MtlsTrustBundleFile != "" {
+		mtls, err := newMtlsVerifier(appConfig.MtlsTrustBundleFile, appConfig.MtlsSpiffeMappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: failed to configure mtls: %w", err)
+		}
+		a.mtls = mtls
+	}
+
+	if appConfig.AuthStrategy == config.AuthStrategyIntrospect || appConfig.AuthStrategy == config.AuthStrategyAuto {
+		a.introspection = newIntrospectionVerifier(appConfig, httpClient)
+		a.introspection.StartCacheSweep()
+	}
+
+	return a, nil
+}
+
+// oidcProviderConfigs returns the providers trusted by appConfig, synthesizing
+// a single entry from the legacy OidcClientId/OidcIssuerUrl fields when
+// OidcProviders isn't set.
+func oidcProviderConfigs(appConfig *config.AppConfig) []config.OidcProviderConfig {
+	if len(appConfig.OidcProviders) > 0 {
+		return appConfig.OidcProviders
+	}
+
+	return []config.OidcProviderConfig{
+		{
+			IssuerUrl: appConfig.OidcIssuerUrl,
+			ClientIds: []string{appConfig.OidcClientId, spnPrefix + appConfig.OidcClientId},
+			JwksUrl:   appConfig.OidcJwksUrl,
+		},
+	}
+}
+
+// setVerifiers overrides the verifiers used for appConfig's issuer, for tests
+// that need to sign tokens against a static key set instead of a live IdP.
+func (a *Authenticator) setVerifiers(verifier, spnVerifier *oidc.IDTokenVerifier) {
+	a.providers[a.config.OidcIssuerUrl] = &providerAuth{
+		issuer:      a.config.OidcIssuerUrl,
+		groupsClaim: defaultGroupsClaim,
+		verifiers: []clientVerifier{
+			{clientID: a.config.OidcClientId, verifier: verifier},
+			{clientID: spnPrefix + a.config.OidcClientId, verifier: spnVerifier},
+		},
+	}
+}
+
+// Close stops every provider's background JWKS refresh loop, the policy
+// engine's hot-reload loop, and the introspection cache sweep, if configured.
+func (a *Authenticator) Close() {
+	for _, jwks := range a.jwksManagers {
+		jwks.stopBackgroundRefresh()
+	}
+	if a.policy != nil {
+		a.policy.StopHotReload()
+	}
+	if a.introspection != nil {
+		a.introspection.StopCacheSweep()
+	}
+}
+
+// VerifyToken is an echo middleware that validates the bearer token from the
+// Authorization header against the provider named by its unverified iss
+// claim, rejecting tokens from issuers the authenticator doesn't trust.
+func (a *Authenticator) VerifyToken() echo.MiddlewareFunc {
+	return a.wrapAuthenticate(a.authenticateToken)
+}
+
+// authenticateToken is the bearer-token half of VerifyToken, factored out so
+// it can be composed with other authentication methods (see
+// VerifyTokenOrClientCert). It dispatches to the signed-JWT or opaque-token
+// introspection path according to config.AppConfig.AuthStrategy.
+func (a *Authenticator) authenticateToken(c echo.Context) error {
+	rawToken, err := extractBearerToken(c.Request())
+	if err != nil {
+		return deny(http.StatusBadRequest, "missing_token")
+	}
+
+	switch a.config.AuthStrategy {
+	case config.AuthStrategyIntrospect:
+		return a.authenticateIntrospectedToken(c, rawToken)
+	case config.AuthStrategyAuto:
+		if looksLikeJWT(rawToken) {
+			return a.authenticateJWT(c, rawToken)
+		}
+		return a.authenticateIntrospectedToken(c, rawToken)
+	default:
+		return a.authenticateJWT(c, rawToken)
+	}
+}
+
+// authenticateJWT verifies rawToken as a signed JWT against the configured
+// OIDC provider(s).
+func (a *Authenticator) authenticateJWT(c echo.Context, rawToken string) error {
+	issuer, err := unverifiedIssuer(rawToken)
+	if err != nil {
+		return deny(http.StatusForbidden, "malformed_token")
+	}
+
+	provider, ok := a.providers[issuer]
+	if !ok {
+		return deny(http.StatusForbidden, "bad_iss")
+	}
+
+	var idToken *oidc.IDToken
+	var clientID string
+	var firstErr error
+	for _, cv := range provider.verifiers {
+		var verifyErr error
+		idToken, verifyErr = cv.verifier.Verify(c.Request().Context(), rawToken)
+		if verifyErr == nil {
+			clientID = cv.clientID
+			break
+		}
+		if firstErr == nil {
+			firstErr = verifyErr
+		}
+	}
+	if idToken == nil {
+		return deny(http.StatusForbidden, classifyVerifyError(firstErr))
+	}
+
+	var rawClaims map[string]interface{}
+	if err := idToken.Claims(&rawClaims); err != nil {
+		return deny(http.StatusForbidden, "invalid_claims")
+	}
+
+	c.Set(claimsContextKey, claims{
+		Groups: stringSliceClaim(rawClaims, provider.groupsClaim),
+		Roles:  stringSliceClaim(rawClaims, "roles"),
+		OID:    stringClaim(rawClaims, "oid"),
+		Tenant: stringClaim(rawClaims, "tid"),
+		Raw:    rawClaims,
+	})
+	c.Set(IssuerContextKey, issuer)
+	c.Set(ClientIDContextKey, clientID)
+
+	return nil
+}
+
+// VerifyGroupAccess is an echo middleware, applied after VerifyToken, that
+// denies access unless the token's groups claim contains groupID.
+func (a *Authenticator) VerifyGroupAccess(groupID string) echo.MiddlewareFunc {
+	return a.wrapAuthenticate(func(c echo.Context) error {
+		tokenClaims, ok := c.Get(claimsContextKey).(claims)
+		if !ok {
+			return deny(http.StatusForbidden, "missing_claims")
+		}
+
+		for _, group := range tokenClaims.Groups {
+			if group == groupID {
+				return nil
+			}
+		}
+
+		return deny(http.StatusForbidden, "group_denied")
+	})
+}
+
+// stringClaim returns claims[key] as a string, or "" if it's absent or not a
+// string.
+func stringClaim(claims map[string]interface{}, key string) string {
+	s, _ := claims[key].(string)
+	return s
+}
+
+// stringSliceClaim returns claims[key] as a []string, or nil if it's absent
+// or not a list of strings.
+func stringSliceClaim(claims map[string]interface{}, key string) []string {
+	raw, ok := claims[key].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
+
+// extractBearerToken pulls the raw token out of the Authorization header.
+func extractBearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get(echo.HeaderAuthorization)
+	if header == "" {
+		return "", errMissingAuthHeader
+	}
+
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return "", errMissingBearerToken
+	}
+
+	return strings.TrimPrefix(header, prefix), nil
+}
+
+// unverifiedIssuer reads the iss claim out of rawToken's payload without
+// verifying its signature, so VerifyToken can pick the right provider to
+// verify against.
+func unverifiedIssuer(rawToken string) (string, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return "", errMalformedToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errMalformedToken
+	}
+
+	var body struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return "", errMalformedToken
+	}
+
+	if body.Issuer == "" {
+		return "", errMalformedToken
+	}
+
+	return body.Issuer, nil
+}
+
+var errMalformedToken = errors.New("malformed token")
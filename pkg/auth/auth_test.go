@@ -36,6 +36,14 @@ const (
 	invalidDummySigningKeyFile = "../../test/testdata/invalidDummyRsaPrivateKey.pem"
 )
 
+// recordingAuditSink captures the last AuditRecord it receives, so tests can
+// assert on the reason code an authentication failure was classified with.
+type recordingAuditSink struct {
+	last AuditRecord
+}
+
+func (s *recordingAuditSink) Audit(record AuditRecord) { s.last = record }
+
 // staticKeySet implements oidc.KeySet
 type staticKeySet struct {
 	keys []*jose.JSONWebKey
@@ -72,6 +80,7 @@ func TestToken(t *testing.T) {
 		authHeader        string
 		signingKeyFile    string
 		verifyGroupAccess bool
+		expectedReason    string
 	}{
 		{
 			name:           "valid token",
@@ -129,12 +138,14 @@ func TestToken(t *testing.T) {
 			authHeader:     jwt.BuildAuthHeader(appConfig, false, dummySigningKeyFile, signingKeyPrivate, jwt.Claim{}),
 			expectedStatus: http.StatusForbidden,
 			signingKeyFile: invalidDummySigningKeyFile,
+			expectedReason: "sig_invalid",
 		},
 		{
 			name:           "expired token",
 			authHeader:     jwt.BuildAuthHeader(appConfig, true, dummySigningKeyFile, signingKeyPrivate, jwt.Claim{}),
 			expectedStatus: http.StatusForbidden,
 			signingKeyFile: dummySigningKeyFile,
+			expectedReason: "expired",
 		},
 		{
 			name:           "invalid aud",
@@ -166,6 +177,9 @@ func TestToken(t *testing.T) {
 			t.Fatalf("Failed to initialize authenticator: %v", err)
 		}
 
+		sink := &recordingAuditSink{}
+		auth.AddAuditSink(sink)
+
 		pubKeys := []*jose.JSONWebKey{jwt.GetSigningKey(tc.signingKeyFile, signingKeyPublic)}
 		auth.setVerifiers(
 			oidc.NewVerifier(
@@ -188,5 +202,8 @@ func TestToken(t *testing.T) {
 		}
 		test.NoError(h(c))
 		assert.Equal(t, tc.expectedStatus, c.Response().Status)
+		if tc.expectedReason != "" {
+			assert.Equal(t, tc.expectedReason, sink.last.Reason, tc.name)
+		}
 	}
 }
@@ -0,0 +1,341 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adobe/cluster-registry/pkg/config"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errTokenInactive     = errors.New("token is not active")
+	errUntrustedAudience = errors.New("token audience is not trusted")
+)
+
+// IntrospectionVerifier authenticates opaque tokens via RFC 7662 OAuth 2.0
+// Token Introspection, for IdPs that don't issue self-contained JWTs.
+// It caches positive results until the token's exp (capped at maxCacheTTL)
+// and negative results for negativeCacheTTL, to rate-limit lookups against
+// the introspection endpoint.
+type IntrospectionVerifier struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	groupsClaim  string
+	// trustedAudiences are the client IDs accepted as the token's aud. A nil
+	// slice skips aud validation.
+	trustedAudiences []string
+
+	maxCacheTTL      time.Duration
+	negativeCacheTTL time.Duration
+
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]introspectionCacheEntry
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// cacheSweepInterval is how often StartCacheSweep purges expired entries
+// from the introspection cache.
+const cacheSweepInterval = time.Minute
+
+type introspectionCacheEntry struct {
+	active    bool
+	claims    claims
+	expiresAt time.Time
+}
+
+// introspectionResponse is the subset of the RFC 7662 response body the
+// authenticator cares about.
+type introspectionResponse struct {
+	Active bool            `json:"active"`
+	Aud    json.RawMessage `json:"aud"`
+	Exp    int64           `json:"exp"`
+	Groups []string        `json:"groups"`
+	Roles  []string        `json:"roles"`
+	Sub    string          `json:"sub"`
+}
+
+// newIntrospectionVerifier builds an IntrospectionVerifier from appConfig,
+// trusting only the aud values configured for appConfig's OIDC provider(s).
+func newIntrospectionVerifier(appConfig *config.AppConfig, httpClient *http.Client) *IntrospectionVerifier {
+	groupsClaim := appConfig.IntrospectionGroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+
+	var trustedAudiences []string
+	for _, providerConfig := range oidcProviderConfigs(appConfig) {
+		trustedAudiences = append(trustedAudiences, providerConfig.ClientIds...)
+	}
+
+	return &IntrospectionVerifier{
+		endpoint:         appConfig.IntrospectionEndpoint,
+		clientID:         appConfig.IntrospectionClientId,
+		clientSecret:     appConfig.IntrospectionClientSecret,
+		groupsClaim:      groupsClaim,
+		trustedAudiences: trustedAudiences,
+		maxCacheTTL:      appConfig.IntrospectionMaxCacheTTL,
+		negativeCacheTTL: appConfig.IntrospectionNegativeCacheTTL,
+		httpClient:       httpClient,
+		cache:            map[string]introspectionCacheEntry{},
+		stopCh:           make(chan struct{}),
+	}
+}
+
+// StartCacheSweep periodically purges cache entries past their expiresAt, so
+// tokens presented once (valid or not) don't stay resident in memory forever.
+func (v *IntrospectionVerifier) StartCacheSweep() {
+	go func() {
+		ticker := time.NewTicker(cacheSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				v.sweep()
+			case <-v.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// StopCacheSweep stops the sweep loop started by StartCacheSweep.
+func (v *IntrospectionVerifier) StopCacheSweep() {
+	v.stopOnce.Do(func() { close(v.stopCh) })
+}
+
+// sweep removes every cache entry that expired before now.
+func (v *IntrospectionVerifier) sweep() {
+	now := time.Now()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for token, entry := range v.cache {
+		if now.After(entry.expiresAt) {
+			delete(v.cache, token)
+		}
+	}
+}
+
+// Verify introspects rawToken, returning the caller's claims if the
+// introspection endpoint reports it active, or an error otherwise.
+func (v *IntrospectionVerifier) Verify(c *http.Request, rawToken string) (claims, error) {
+	if entry, ok := v.cached(rawToken); ok {
+		if !entry.active {
+			return claims{}, errTokenInactive
+		}
+		return entry.claims, nil
+	}
+
+	resp, err := v.introspect(c, rawToken)
+	if err != nil {
+		return claims{}, fmt.Errorf("auth: introspection request failed: %w", err)
+	}
+
+	if !resp.Active {
+		v.cacheNegative(rawToken)
+		return claims{}, errTokenInactive
+	}
+
+	if !v.audienceTrusted(resp.Aud) {
+		v.cacheNegative(rawToken)
+		return claims{}, errUntrustedAudience
+	}
+
+	tokenClaims := claims{
+		Groups: resp.Groups,
+		Roles:  resp.Roles,
+		OID:    resp.Sub,
+		Raw:    map[string]interface{}{"aud": firstAudience(resp.Aud)},
+	}
+	v.cachePositive(rawToken, tokenClaims, resp.Exp)
+
+	return tokenClaims, nil
+}
+
+func (v *IntrospectionVerifier) cached(rawToken string) (introspectionCacheEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, ok := v.cache[rawToken]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return introspectionCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (v *IntrospectionVerifier) cachePositive(rawToken string, tokenClaims claims, exp int64) {
+	ttl := v.maxCacheTTL
+	if exp > 0 {
+		if untilExp := time.Until(time.Unix(exp, 0)); untilExp < ttl {
+			ttl = untilExp
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache[rawToken] = introspectionCacheEntry{active: true, claims: tokenClaims, expiresAt: time.Now().Add(ttl)}
+}
+
+func (v *IntrospectionVerifier) cacheNegative(rawToken string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache[rawToken] = introspectionCacheEntry{active: false, expiresAt: time.Now().Add(v.negativeCacheTTL)}
+}
+
+// audienceTrusted reports whether rawAud, which per RFC 7662 may be a single
+// string or a JSON array of strings, contains one of v's trustedAudiences.
+// A verifier configured with no trusted audiences skips the check, and a
+// response that carries no aud at all is accepted as well.
+func (v *IntrospectionVerifier) audienceTrusted(rawAud json.RawMessage) bool {
+	if len(v.trustedAudiences) == 0 || len(rawAud) == 0 {
+		return true
+	}
+
+	var auds []string
+	var single string
+	switch {
+	case json.Unmarshal(rawAud, &single) == nil:
+		auds = []string{single}
+	case json.Unmarshal(rawAud, &auds) == nil:
+	default:
+		return false
+	}
+
+	for _, aud := range auds {
+		for _, trusted := range v.trustedAudiences {
+			if aud == trusted {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// firstAudience returns the first aud value out of rawAud, which per RFC
+// 7662 may be a single string or a JSON array of strings, for reporting in
+// audit records. Returns "" if rawAud is empty or unparseable.
+func firstAudience(rawAud json.RawMessage) string {
+	var single string
+	if json.Unmarshal(rawAud, &single) == nil {
+		return single
+	}
+
+	var auds []string
+	if json.Unmarshal(rawAud, &auds) == nil && len(auds) > 0 {
+		return auds[0]
+	}
+
+	return ""
+}
+
+// introspect POSTs rawToken to the introspection endpoint using client
+// credentials, per RFC 7662 section 2.1.
+func (v *IntrospectionVerifier) introspect(c *http.Request, rawToken string) (*introspectionResponse, error) {
+	body := url.Values{"token": {rawToken}}
+
+	req, err := http.NewRequestWithContext(c.Context(), http.MethodPost, v.endpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(v.clientID, v.clientSecret)
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+
+	var introspected introspectionResponse
+	if err := json.Unmarshal(raw, &introspected); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+
+	if v.groupsClaim != defaultGroupsClaim {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("failed to decode introspection response: %w", err)
+		}
+		introspected.Groups = stringSliceClaim(fields, v.groupsClaim)
+	}
+
+	return &introspected, nil
+}
+
+// authenticateIntrospectedToken is the opaque-token half of
+// authenticateToken, used when AuthStrategy is "introspect" or "auto".
+func (a *Authenticator) authenticateIntrospectedToken(c echo.Context, rawToken string) error {
+	if a.introspection == nil {
+		return deny(http.StatusForbidden, "introspection_not_configured")
+	}
+
+	tokenClaims, err := a.introspection.Verify(c.Request(), rawToken)
+	if err != nil {
+		return deny(http.StatusForbidden, "introspection_denied")
+	}
+
+	c.Set(claimsContextKey, tokenClaims)
+	c.Set(IssuerContextKey, "introspect")
+	if clientID, _ := tokenClaims.Raw["aud"].(string); clientID != "" {
+		c.Set(ClientIDContextKey, clientID)
+	}
+
+	return nil
+}
+
+// looksLikeJWT reports whether rawToken has the three base64url segments of
+// a JWT, without verifying its signature. Used by the "auto" strategy to
+// pick between the JWT and introspection paths.
+func looksLikeJWT(rawToken string) bool {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		if _, err := base64.RawURLEncoding.DecodeString(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIntrospectionEndpoint returns active=true for "valid-token" and
+// active=false for everything else, recording how many times it was called.
+func fakeIntrospectionEndpoint(t *testing.T, calls *int) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+
+		assert.NoError(t, r.ParseForm())
+		token := r.PostFormValue("token")
+
+		resp := introspectionResponse{Active: token == "valid-token"}
+		if resp.Active {
+			resp.Aud = json.RawMessage(`"oidc-client-id"`)
+			resp.Groups = []string{"1111-2222-3333-4444"}
+			resp.Sub = "00000000-0000-0000-0000-000000000000"
+			resp.Exp = time.Now().Add(time.Hour).Unix()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+}
+
+func TestIntrospectionVerifier(t *testing.T) {
+	var calls int
+	server := fakeIntrospectionEndpoint(t, &calls)
+	defer server.Close()
+
+	test := assert.New(t)
+
+	tcs := []struct {
+		name          string
+		token         string
+		expectActive  bool
+		expectGroups  []string
+		expectInCache bool
+	}{
+		{
+			name:         "active token",
+			token:        "valid-token",
+			expectActive: true,
+			expectGroups: []string{"1111-2222-3333-4444"},
+		},
+		{
+			name:         "inactive token",
+			token:        "revoked-token",
+			expectActive: false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &IntrospectionVerifier{
+				endpoint:         server.URL,
+				groupsClaim:      defaultGroupsClaim,
+				trustedAudiences: []string{"oidc-client-id"},
+				maxCacheTTL:      time.Minute,
+				negativeCacheTTL: time.Minute,
+				httpClient:       server.Client(),
+				cache:            map[string]introspectionCacheEntry{},
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/api/v1/clusters", nil)
+
+			claims, err := v.Verify(req, tc.token)
+			if tc.expectActive {
+				test.NoError(err, tc.name)
+				test.Equal(tc.expectGroups, claims.Groups, tc.name)
+			} else {
+				test.Error(err, tc.name)
+			}
+		})
+	}
+}
+
+func TestIntrospectionVerifierCachesResults(t *testing.T) {
+	var calls int
+	server := fakeIntrospectionEndpoint(t, &calls)
+	defer server.Close()
+
+	v := &IntrospectionVerifier{
+		endpoint:         server.URL,
+		groupsClaim:      defaultGroupsClaim,
+		maxCacheTTL:      time.Minute,
+		negativeCacheTTL: time.Minute,
+		httpClient:       server.Client(),
+		cache:            map[string]introspectionCacheEntry{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/v1/clusters", nil)
+
+	_, err := v.Verify(req, "valid-token")
+	assert.NoError(t, err)
+	_, err = v.Verify(req, "valid-token")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, calls, "second lookup should be served from cache")
+}
+
+func TestIntrospectionVerifierSweepPurgesExpiredEntries(t *testing.T) {
+	v := &IntrospectionVerifier{
+		cache: map[string]introspectionCacheEntry{
+			"expired": {active: true, expiresAt: time.Now().Add(-time.Minute)},
+			"live":    {active: true, expiresAt: time.Now().Add(time.Hour)},
+		},
+	}
+
+	v.sweep()
+
+	_, expiredStillCached := v.cache["expired"]
+	_, liveStillCached := v.cache["live"]
+	assert.False(t, expiredStillCached)
+	assert.True(t, liveStillCached)
+}
+
+func TestLooksLikeJWT(t *testing.T) {
+	test := assert.New(t)
+
+	test.True(looksLikeJWT("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiIxMjMifQ.c2ln"))
+	test.False(looksLikeJWT("opaque-introspection-token"))
+	test.False(looksLikeJWT("only.two"))
+}
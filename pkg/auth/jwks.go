@@ -0,0 +1,344 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adobe/cluster-registry/pkg/config"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var (
+	errMissingAuthHeader  = errors.New("missing authorization header")
+	errMissingBearerToken = errors.New("authorization header is not a bearer token")
+	errKeyNotFound        = errors.New("signing key not found in jwks")
+)
+
+// jwksManager owns the discovered JWKS endpoint and the background refresh
+// loop that keeps the key cache warm.
+type jwksManager struct {
+	keySet          *cachingKeySet
+	refreshInterval time.Duration
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// newJwksManager builds the caching key set used to verify token signatures
+// for issuerURL. Resolving jwks_uri (unless jwksURLOverride is set) requires
+// a live HTTP call to the issuer's discovery document, so it is deferred to
+// the key set's first use rather than performed here, keeping construction
+// network-free for tests and callers that substitute their own verifiers.
+func newJwksManager(httpClient *http.Client, issuerURL, jwksURLOverride string, appConfig *config.AppConfig) *jwksManager {
+	minRefresh := appConfig.OidcJwksMinRefreshInterval
+	if minRefresh <= 0 {
+		minRefresh = time.Minute
+	}
+
+	refreshInterval := appConfig.OidcJwksRefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = time.Hour
+	}
+
+	return &jwksManager{
+		keySet: &cachingKeySet{
+			issuerURL:       issuerURL,
+			jwksURLOverride: jwksURLOverride,
+			httpClient:      httpClient,
+			minRefreshGap:   minRefresh,
+			keysByKid:       map[string]*jose.JSONWebKey{},
+		},
+		refreshInterval: refreshInterval,
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// startBackgroundRefresh periodically refreshes the key cache regardless of
+// kid misses, so a key rotation at the IdP is picked up before any client
+// presents a token signed with the new key.
+func (m *jwksManager) startBackgroundRefresh() {
+	go func() {
+		ticker := time.NewTicker(m.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.keySet.refresh(context.Background()); err != nil {
+					log.Printf("auth: background jwks refresh failed: %v", err)
+				}
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (m *jwksManager) stopBackgroundRefresh() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// newOidcHTTPClient builds the http.Client used for OIDC discovery and JWKS
+// fetches, honoring the configured timeout and trusted CA bundle.
+func newOidcHTTPClient(appConfig *config.AppConfig) (*http.Client, error) {
+	timeout := appConfig.OidcHttpTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if appConfig.OidcTrustedCaFile != "" {
+		pem, err := os.ReadFile(appConfig.OidcTrustedCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted ca file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", appConfig.OidcTrustedCaFile)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// discoverJwksURL fetches the issuer's discovery document and returns its
+// jwks_uri.
+func discoverJwksURL(ctx context.Context, httpClient *http.Client, issuerURL string) (string, error) {
+	wellKnown := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("discovery request to %s returned status %d", wellKnown, resp.StatusCode)
+	}
+
+	var doc struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+
+	if doc.JwksURI == "" {
+		return "", fmt.Errorf("discovery document at %s has no jwks_uri", wellKnown)
+	}
+
+	return doc.JwksURI, nil
+}
+
+// cachingKeySet is an oidc.KeySet that caches signing keys by kid, refreshing
+// from jwksURL on a cache miss no more often than minRefreshGap, and honoring
+// the Cache-Control/Expires headers returned by the JWKS endpoint. jwksURL
+// itself is resolved lazily: either from jwksURLOverride, or by discovering
+// issuerURL's jwks_uri on first refresh.
+type cachingKeySet struct {
+	issuerURL       string
+	jwksURLOverride string
+	httpClient      *http.Client
+	minRefreshGap   time.Duration
+
+	mu          sync.RWMutex
+	jwksURL     string
+	keysByKid   map[string]*jose.JSONWebKey
+	lastRefresh time.Time
+	expiresAt   time.Time
+}
+
+// resolveJwksURL returns the JWKS endpoint to fetch, discovering it from
+// issuerURL's discovery document on first call if jwksURLOverride wasn't set.
+func (k *cachingKeySet) resolveJwksURL(ctx context.Context) (string, error) {
+	k.mu.RLock()
+	jwksURL := k.jwksURL
+	k.mu.RUnlock()
+	if jwksURL != "" {
+		return jwksURL, nil
+	}
+
+	jwksURL = k.jwksURLOverride
+	if jwksURL == "" {
+		var err error
+		jwksURL, err = discoverJwksURL(ctx, k.httpClient, k.issuerURL)
+		if err != nil {
+			return "", fmt.Errorf("auth: failed to discover jwks_uri for %s: %w", k.issuerURL, err)
+		}
+	}
+
+	k.mu.Lock()
+	k.jwksURL = jwksURL
+	k.mu.Unlock()
+
+	return jwksURL, nil
+}
+
+// VerifySignature implements oidc.KeySet.
+func (k *cachingKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("auth: failed to parse jwt: %w", err)
+	}
+
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("auth: jwt must have exactly one signature")
+	}
+	kid := jws.Signatures[0].Header.KeyID
+
+	key, err := k.keyByKid(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return jws.Verify(key)
+}
+
+// keyByKid returns the cached key for kid, transparently refreshing the cache
+// on a miss or once the cached set has expired.
+func (k *cachingKeySet) keyByKid(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	k.mu.RLock()
+	key, ok := k.keysByKid[kid]
+	fresh := time.Now().Before(k.expiresAt)
+	k.mu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	if !k.shouldRefresh() {
+		return nil, errKeyNotFound
+	}
+
+	if err := k.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, errKeyNotFound
+}
+
+func (k *cachingKeySet) lookup(kid string) (*jose.JSONWebKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keysByKid[kid]
+	return key, ok
+}
+
+// shouldRefresh reports whether enough time has passed since the last refresh
+// to allow another one, rate-limiting refreshes triggered by kid misses.
+func (k *cachingKeySet) shouldRefresh() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if time.Now().After(k.expiresAt) {
+		return true
+	}
+
+	return time.Since(k.lastRefresh) >= k.minRefreshGap
+}
+
+// refresh fetches the JWKS document and replaces the key cache.
+func (k *cachingKeySet) refresh(ctx context.Context) error {
+	jwksURL, err := k.resolveJwksURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks request to %s returned status %d", jwksURL, resp.StatusCode)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return fmt.Errorf("failed to decode jwks response: %w", err)
+	}
+
+	byKid := make(map[string]*jose.JSONWebKey, len(keySet.Keys))
+	for i := range keySet.Keys {
+		key := keySet.Keys[i]
+		byKid[key.KeyID] = &key
+	}
+
+	k.mu.Lock()
+	k.keysByKid = byKid
+	k.lastRefresh = time.Now()
+	k.expiresAt = cacheExpiry(resp.Header, k.lastRefresh)
+	k.mu.Unlock()
+
+	return nil
+}
+
+// cacheExpiry derives the next mandatory refresh time from the response's
+// Cache-Control max-age or Expires header, falling back to a conservative
+// default when neither is present.
+func cacheExpiry(header http.Header, fetchedAt time.Time) time.Time {
+	if cc := header.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.TrimSpace(directive)
+			if !strings.HasPrefix(directive, "max-age=") {
+				continue
+			}
+
+			var seconds int
+			if _, err := fmt.Sscanf(directive, "max-age=%d", &seconds); err == nil && seconds > 0 {
+				return fetchedAt.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+
+	if exp := header.Get("Expires"); exp != "" {
+		if t, err := time.Parse(http.TimeFormat, exp); err == nil && t.After(fetchedAt) {
+			return t
+		}
+	}
+
+	return fetchedAt.Add(10 * time.Minute)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// spiffeMapping maps a SPIFFE ID, or a "spiffe://trust-domain/*" pattern, to
+// the groups a matching caller should be granted.
+type spiffeMapping struct {
+	SpiffeID string   `json:"spiffeId"`
+	Groups   []string `json:"groups"`
+}
+
+// mtlsVerifier holds the trust bundle and SPIFFE-to-group mappings used by
+// VerifyClientCert.
+type mtlsVerifier struct {
+	trustRoots *x509.CertPool
+	mappings   []spiffeMapping
+}
+
+// newMtlsVerifier loads the trust bundle at trustBundleFile and, if set, the
+// SPIFFE ID to group mappings at mappingFile.
+func newMtlsVerifier(trustBundleFile, mappingFile string) (*mtlsVerifier, error) {
+	pem, err := os.ReadFile(trustBundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mtls trust bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", trustBundleFile)
+	}
+
+	v := &mtlsVerifier{trustRoots: pool}
+
+	if mappingFile != "" {
+		raw, err := os.ReadFile(mappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spiffe mapping file: %w", err)
+		}
+		if err := json.Unmarshal(raw, &v.mappings); err != nil {
+			return nil, fmt.Errorf("failed to parse spiffe mapping file %s: %w", mappingFile, err)
+		}
+	}
+
+	return v, nil
+}
+
+// groupsFor returns the groups a caller presenting spiffeID should be granted.
+// Absent any mapping, the SPIFFE ID itself is used as the caller's sole
+// group.
+func (v *mtlsVerifier) groupsFor(spiffeID string) []string {
+	for _, mapping := range v.mappings {
+		if mapping.SpiffeID == spiffeID {
+			return mapping.Groups
+		}
+		if prefix, ok := strings.CutSuffix(mapping.SpiffeID, "/*"); ok && strings.HasPrefix(spiffeID, prefix+"/") {
+			return mapping.Groups
+		}
+	}
+
+	return []string{spiffeID}
+}
+
+// VerifyClientCert is an echo middleware that authenticates the caller from
+// their mTLS client certificate, extracting its SPIFFE ID (the URI SAN) and
+// mapping it to an identity/groups the same way VerifyToken maps OIDC claims.
+func (a *Authenticator) VerifyClientCert() echo.MiddlewareFunc {
+	return a.wrapAuthenticate(a.authenticateClientCert)
+}
+
+// VerifyTokenOrClientCert is an echo middleware that accepts either an OIDC
+// bearer token or an mTLS client certificate, so a single route can serve
+// both human operators and cluster agents.
+func (a *Authenticator) VerifyTokenOrClientCert() echo.MiddlewareFunc {
+	return a.wrapAuthenticate(func(c echo.Context) error {
+		if err := a.authenticateToken(c); err == nil {
+			return nil
+		}
+		return a.authenticateClientCert(c)
+	})
+}
+
+// authenticateClientCert is the mTLS half of VerifyClientCert, factored out
+// so it can be composed with other authentication methods.
+func (a *Authenticator) authenticateClientCert(c echo.Context) error {
+	if a.mtls == nil {
+		return deny(http.StatusForbidden, "mtls_not_configured")
+	}
+
+	peerCerts := c.Request().TLS
+	if peerCerts == nil || len(peerCerts.PeerCertificates) == 0 {
+		return deny(http.StatusBadRequest, "no_client_cert")
+	}
+
+	leaf := peerCerts.PeerCertificates[0]
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range peerCerts.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         a.mtls.trustRoots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return deny(http.StatusForbidden, "cert_invalid")
+	}
+
+	spiffeID, err := spiffeIDFromCert(leaf)
+	if err != nil {
+		return deny(http.StatusForbidden, "no_spiffe_id")
+	}
+
+	c.Set(claimsContextKey, claims{
+		Groups: a.mtls.groupsFor(spiffeID),
+		OID:    spiffeID,
+	})
+	c.Set(IssuerContextKey, "spiffe")
+	c.Set(ClientIDContextKey, spiffeID)
+
+	return nil
+}
+
+// spiffeIDFromCert extracts the SPIFFE ID carried in cert's SAN URI field.
+func spiffeIDFromCert(cert *x509.Certificate) (string, error) {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String(), nil
+		}
+	}
+
+	return "", errors.New("certificate has no spiffe:// URI SAN")
+}
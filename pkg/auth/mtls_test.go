@@ -0,0 +1,128 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// issueSpiffeCert mints a leaf certificate, signed by a freshly generated CA,
+// carrying spiffeID as its sole URI SAN. It returns the leaf and a CertPool
+// trusting the issuing CA.
+func issueSpiffeCert(t *testing.T, spiffeID string) (*x509.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	assert.NoError(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	uri, err := url.Parse(spiffeID)
+	assert.NoError(t, err)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{uri},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	leafCert, err := x509.ParseCertificate(leafDER)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return leafCert, pool
+}
+
+func TestAuthenticateClientCert(t *testing.T) {
+	leaf, pool := issueSpiffeCert(t, "spiffe://example.org/ns/default/sa/foo")
+
+	a := &Authenticator{mtls: &mtlsVerifier{
+		trustRoots: pool,
+		mappings: []spiffeMapping{
+			{SpiffeID: "spiffe://example.org/*", Groups: []string{"cluster-agents"}},
+		},
+	}}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/v1/clusters", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	res := httptest.NewRecorder()
+	c := e.NewContext(req, res)
+
+	err := a.authenticateClientCert(c)
+	assert.NoError(t, err)
+
+	got, ok := c.Get(claimsContextKey).(claims)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"cluster-agents"}, got.Groups)
+	assert.Equal(t, "spiffe://example.org/ns/default/sa/foo", got.OID)
+}
+
+func TestAuthenticateClientCertUntrusted(t *testing.T) {
+	leaf, _ := issueSpiffeCert(t, "spiffe://example.org/ns/default/sa/foo")
+	_, otherPool := issueSpiffeCert(t, "spiffe://other.org/ns/default/sa/bar")
+
+	a := &Authenticator{mtls: &mtlsVerifier{trustRoots: otherPool}}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/v1/clusters", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	res := httptest.NewRecorder()
+	c := e.NewContext(req, res)
+
+	err := a.authenticateClientCert(c)
+	assert.Error(t, err)
+}
+
+func TestMtlsVerifierGroupsFor(t *testing.T) {
+	v := &mtlsVerifier{mappings: []spiffeMapping{
+		{SpiffeID: "spiffe://example.org/ns/default/*", Groups: []string{"g1"}},
+	}}
+
+	assert.Equal(t, []string{"g1"}, v.groupsFor("spiffe://example.org/ns/default/sa/foo"))
+	assert.Equal(t, []string{"spiffe://other.org/sa/bar"}, v.groupsFor("spiffe://other.org/sa/bar"))
+}
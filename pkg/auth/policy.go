@@ -0,0 +1,225 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const wildcard = "*"
+
+// Policy grants the subjects matching Groups/Roles permission to perform any
+// of Actions against any of Resources. An empty Groups/Roles list never
+// matches, to avoid an accidentally permissive rule; use "*" to match
+// everyone.
+type Policy struct {
+	Groups    []string `json:"groups,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Actions   []string `json:"actions"`
+	Resources []string `json:"resources"`
+}
+
+// PolicyEngine evaluates a set of Policy rules, loaded from a JSON file and
+// optionally hot-reloaded as that file changes.
+type PolicyEngine struct {
+	path string
+
+	mu       sync.RWMutex
+	policies []Policy
+	modTime  time.Time
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewPolicyEngine loads the policy file at path.
+func NewPolicyEngine(path string) (*PolicyEngine, error) {
+	pe := &PolicyEngine{path: path, stopCh: make(chan struct{})}
+	if err := pe.reload(); err != nil {
+		return nil, err
+	}
+
+	return pe, nil
+}
+
+// StartHotReload polls the policy file every interval and reloads it when its
+// modification time changes.
+func (pe *PolicyEngine) StartHotReload(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := pe.reloadIfChanged(); err != nil {
+					fmt.Printf("auth: failed to reload authorization policy: %v\n", err)
+				}
+			case <-pe.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// StopHotReload stops the hot-reload loop started by StartHotReload.
+func (pe *PolicyEngine) StopHotReload() {
+	pe.stopOnce.Do(func() { close(pe.stopCh) })
+}
+
+func (pe *PolicyEngine) reloadIfChanged() error {
+	info, err := os.Stat(pe.path)
+	if err != nil {
+		return err
+	}
+
+	pe.mu.RLock()
+	unchanged := info.ModTime().Equal(pe.modTime)
+	pe.mu.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	return pe.reload()
+}
+
+func (pe *PolicyEngine) reload() error {
+	info, err := os.Stat(pe.path)
+	if err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(pe.path)
+	if err != nil {
+		return err
+	}
+
+	var policies []Policy
+	if err := json.Unmarshal(raw, &policies); err != nil {
+		return fmt.Errorf("failed to parse policy file %s: %w", pe.path, err)
+	}
+
+	pe.mu.Lock()
+	pe.policies = policies
+	pe.modTime = info.ModTime()
+	pe.mu.Unlock()
+
+	return nil
+}
+
+// Allowed reports whether subject is permitted to perform action on resource
+// under any loaded policy.
+func (pe *PolicyEngine) Allowed(subject claims, action, resource string) bool {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	for _, policy := range pe.policies {
+		if !matchesSubject(policy, subject) {
+			continue
+		}
+		if !matchesAny(policy.Actions, action) {
+			continue
+		}
+		if !matchesResource(policy.Resources, resource) {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+func matchesSubject(policy Policy, subject claims) bool {
+	if matchesAny(policy.Groups, wildcard) || intersects(policy.Groups, subject.Groups) {
+		return true
+	}
+	return matchesAny(policy.Roles, wildcard) || intersects(policy.Roles, subject.Roles)
+}
+
+func intersects(allowed, have []string) bool {
+	for _, a := range allowed {
+		for _, h := range have {
+			if a == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAny(values []string, want string) bool {
+	for _, v := range values {
+		if v == wildcard || v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesResource reports whether resource matches any of the given patterns.
+// A pattern of "*" matches anything; a pattern ending in "/*" matches any
+// resource sharing its prefix.
+func matchesResource(patterns []string, resource string) bool {
+	for _, pattern := range patterns {
+		if pattern == wildcard || pattern == resource {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(resource, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPermission is an echo middleware, applied after VerifyToken, that
+// denies access unless the authenticated caller is allowed to perform action
+// on the resource built by substituting echo path parameters into
+// resourceTemplate (e.g. "cluster/{env}").
+func (a *Authenticator) VerifyPermission(action, resourceTemplate string) echo.MiddlewareFunc {
+	return a.wrapAuthenticate(func(c echo.Context) error {
+		if a.policy == nil {
+			return deny(http.StatusForbidden, "policy_not_configured")
+		}
+
+		tokenClaims, ok := c.Get(claimsContextKey).(claims)
+		if !ok {
+			return deny(http.StatusForbidden, "missing_claims")
+		}
+
+		resource := resolveResource(resourceTemplate, c)
+
+		if !a.policy.Allowed(tokenClaims, action, resource) {
+			return deny(http.StatusForbidden, "policy_denied")
+		}
+
+		return nil
+	})
+}
+
+// resolveResource substitutes "{param}" placeholders in template with the
+// matching echo path parameter from c.
+func resolveResource(template string, c echo.Context) string {
+	resource := template
+	for _, name := range c.ParamNames() {
+		resource = strings.ReplaceAll(resource, "{"+name+"}", c.Param(name))
+	}
+	return resource
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyEngineAllowed(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(policyFile, []byte(`[
+		{"groups": ["1111-2222-3333-4444"], "actions": ["read"], "resources": ["cluster/*"]},
+		{"roles": ["admin"], "actions": ["*"], "resources": ["*"]}
+	]`), 0o600)
+	assert.NoError(t, err)
+
+	pe, err := NewPolicyEngine(policyFile)
+	assert.NoError(t, err)
+
+	test := assert.New(t)
+
+	tcs := []struct {
+		name     string
+		subject  claims
+		action   string
+		resource string
+		allowed  bool
+	}{
+		{
+			name:     "group allowed to read matching resource",
+			subject:  claims{Groups: []string{"1111-2222-3333-4444"}},
+			action:   "read",
+			resource: "cluster/prod",
+			allowed:  true,
+		},
+		{
+			name:     "group denied write action",
+			subject:  claims{Groups: []string{"1111-2222-3333-4444"}},
+			action:   "write",
+			resource: "cluster/prod",
+			allowed:  false,
+		},
+		{
+			name:     "unrelated group denied",
+			subject:  claims{Groups: []string{"aaaa-bbbb-cccc-dddd"}},
+			action:   "read",
+			resource: "cluster/prod",
+			allowed:  false,
+		},
+		{
+			name:     "admin role wildcard allowed",
+			subject:  claims{Roles: []string{"admin"}},
+			action:   "delete",
+			resource: "cluster/region/us-east-1",
+			allowed:  true,
+		},
+		{
+			name:     "no matching policy denied",
+			subject:  claims{},
+			action:   "read",
+			resource: "cluster/prod",
+			allowed:  false,
+		},
+	}
+
+	for _, tc := range tcs {
+		test.Equal(tc.allowed, pe.Allowed(tc.subject, tc.action, tc.resource), tc.name)
+	}
+}
+
+func TestMatchesResource(t *testing.T) {
+	test := assert.New(t)
+
+	test.True(matchesResource([]string{"*"}, "anything"))
+	test.True(matchesResource([]string{"cluster/*"}, "cluster/prod"))
+	test.False(matchesResource([]string{"cluster/*"}, "clusterx/prod"))
+	test.True(matchesResource([]string{"cluster/prod"}, "cluster/prod"))
+	test.False(matchesResource([]string{"cluster/prod"}, "cluster/stage"))
+}
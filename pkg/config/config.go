@@ -0,0 +1,165 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// AppConfig holds the runtime configuration for the cluster-registry API server.
+type AppConfig struct {
+	Port  string `envconfig:"PORT" default:"8080"`
+	Debug bool   `envconfig:"DEBUG" default:"false"`
+
+	// OidcClientId/OidcIssuerUrl configure a single trusted OIDC provider.
+	// Ignored once OidcProviders/OidcProvidersFile is set.
+	OidcClientId         string `envconfig:"OIDC_CLIENT_ID"`
+	OidcIssuerUrl        string `envconfig:"OIDC_ISSUER_URL"`
+	ApiAuthorizedGroupId string `envconfig:"API_AUTHORIZED_GROUP_ID" required:"true"`
+
+	// OidcProvidersFile points at a JSON file of OidcProviderConfig entries,
+	// used to trust more than one issuer/tenant at a time. When set, it takes
+	// precedence over OidcClientId/OidcIssuerUrl.
+	OidcProvidersFile string `envconfig:"OIDC_PROVIDERS_FILE"`
+	// OidcProviders is populated from OidcProvidersFile by NewConfig, or left
+	// empty for the single-provider OidcClientId/OidcIssuerUrl configuration.
+	OidcProviders []OidcProviderConfig `ignored:"true"`
+
+	// OidcJwksUrl overrides the jwks_uri discovered from the issuer's
+	// .well-known/openid-configuration document. Leave empty to use discovery.
+	OidcJwksUrl string `envconfig:"OIDC_JWKS_URL"`
+	// OidcJwksMinRefreshInterval rate-limits JWKS re-fetches triggered by a kid
+	// cache miss, to protect the IdP from being hammered during a bad rollout.
+	OidcJwksMinRefreshInterval time.Duration `envconfig:"OIDC_JWKS_MIN_REFRESH_INTERVAL" default:"1m"`
+	// OidcJwksRefreshInterval is the period of the proactive background JWKS
+	// refresh, independent of any kid cache misses.
+	OidcJwksRefreshInterval time.Duration `envconfig:"OIDC_JWKS_REFRESH_INTERVAL" default:"1h"`
+	// OidcHttpTimeout bounds discovery and JWKS HTTP requests to the IdP.
+	OidcHttpTimeout time.Duration `envconfig:"OIDC_HTTP_TIMEOUT" default:"10s"`
+	// OidcTrustedCaFile, when set, is a PEM bundle of CAs trusted for the IdP's
+	// TLS certificate, in addition to the system trust store.
+	OidcTrustedCaFile string `envconfig:"OIDC_TRUSTED_CA_FILE"`
+
+	// AuthzPolicyFile points at a JSON file of authorization policies
+	// evaluated by VerifyPermission. Leave empty to disable fine-grained
+	// authorization (VerifyPermission then denies every request).
+	AuthzPolicyFile string `envconfig:"AUTHZ_POLICY_FILE"`
+	// AuthzPolicyReloadInterval is how often the policy file is checked for
+	// changes and hot-reloaded.
+	AuthzPolicyReloadInterval time.Duration `envconfig:"AUTHZ_POLICY_RELOAD_INTERVAL" default:"30s"`
+
+	// MtlsTrustBundleFile is a PEM bundle of CA certificates (a static root
+	// bundle, or a SPIFFE trust-domain bundle) trusted to sign client
+	// certificates presented to VerifyClientCert. Leave empty to disable mTLS.
+	MtlsTrustBundleFile string `envconfig:"MTLS_TRUST_BUNDLE_FILE"`
+	// MtlsSpiffeMappingFile points at a JSON file mapping SPIFFE IDs to
+	// groups, analogous to the OIDC groups claim. Leave empty to use the
+	// SPIFFE ID itself as the caller's only group.
+	MtlsSpiffeMappingFile string `envconfig:"MTLS_SPIFFE_MAPPING_FILE"`
+
+	// AuthStrategy selects how VerifyToken validates a bearer token: as a
+	// signed JWT ("jwt"), via RFC 7662 introspection ("introspect"), or by
+	// inspecting its shape and picking one of the above ("auto").
+	AuthStrategy string `envconfig:"AUTH_STRATEGY" default:"jwt"`
+
+	// IntrospectionEndpoint is the RFC 7662 token introspection endpoint used
+	// when AuthStrategy is "introspect" or "auto".
+	IntrospectionEndpoint string `envconfig:"OIDC_INTROSPECTION_ENDPOINT"`
+	// IntrospectionClientId/IntrospectionClientSecret authenticate
+	// cluster-registry to the introspection endpoint.
+	IntrospectionClientId     string `envconfig:"OIDC_INTROSPECTION_CLIENT_ID"`
+	IntrospectionClientSecret string `envconfig:"OIDC_INTROSPECTION_CLIENT_SECRET"`
+	// IntrospectionGroupsClaim is the field of the introspection response
+	// holding the caller's groups. Defaults to "groups".
+	IntrospectionGroupsClaim string `envconfig:"OIDC_INTROSPECTION_GROUPS_CLAIM"`
+	// IntrospectionMaxCacheTTL caps how long a positive introspection result
+	// is cached, even if the token's exp is further out.
+	IntrospectionMaxCacheTTL time.Duration `envconfig:"OIDC_INTROSPECTION_MAX_CACHE_TTL" default:"5m"`
+	// IntrospectionNegativeCacheTTL is how long an inactive/invalid result is
+	// cached, to rate-limit repeated lookups for a bad token.
+	IntrospectionNegativeCacheTTL time.Duration `envconfig:"OIDC_INTROSPECTION_NEGATIVE_CACHE_TTL" default:"10s"`
+
+	// AuditWebhookURL, when set, receives a JSON POST of every authentication/
+	// authorization AuditRecord, in addition to the default stderr log sink.
+	AuditWebhookURL string `envconfig:"AUTH_AUDIT_WEBHOOK_URL"`
+}
+
+// Auth strategies accepted by AppConfig.AuthStrategy.
+const (
+	AuthStrategyJWT        = "jwt"
+	AuthStrategyIntrospect = "introspect"
+	AuthStrategyAuto       = "auto"
+)
+
+// OidcProviderConfig describes a single OIDC issuer trusted by the API, for
+// multi-tenant/multi-issuer federation.
+type OidcProviderConfig struct {
+	// IssuerUrl is the provider's issuer, matched against a token's iss claim.
+	IssuerUrl string `json:"issuerUrl"`
+	// ClientIds lists the aud values accepted for this issuer, including any
+	// "spn:"-prefixed service principal alias.
+	ClientIds []string `json:"clientIds"`
+	// JwksUrl overrides the jwks_uri discovered from IssuerUrl's discovery
+	// document. Leave empty to use discovery.
+	JwksUrl string `json:"jwksUrl,omitempty"`
+	// GroupsClaim is the JWT claim holding the caller's groups for this
+	// provider. Defaults to "groups".
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+}
+
+// NewConfig loads the AppConfig from the process environment.
+func NewConfig() (*AppConfig, error) {
+	appConfig := AppConfig{}
+	if err := envconfig.Process("", &appConfig); err != nil {
+		return nil, err
+	}
+
+	if appConfig.OidcProvidersFile != "" {
+		providers, err := loadOidcProviders(appConfig.OidcProvidersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load oidc providers file: %w", err)
+		}
+		appConfig.OidcProviders = providers
+	}
+
+	if len(appConfig.OidcProviders) == 0 && (appConfig.OidcClientId == "" || appConfig.OidcIssuerUrl == "") {
+		return nil, errors.New("config: either OIDC_PROVIDERS_FILE or both OIDC_CLIENT_ID and OIDC_ISSUER_URL must be set")
+	}
+
+	needsIntrospection := appConfig.AuthStrategy == AuthStrategyIntrospect || appConfig.AuthStrategy == AuthStrategyAuto
+	if needsIntrospection && appConfig.IntrospectionEndpoint == "" {
+		return nil, fmt.Errorf("config: OIDC_INTROSPECTION_ENDPOINT is required when AUTH_STRATEGY is %q", appConfig.AuthStrategy)
+	}
+
+	return &appConfig, nil
+}
+
+// loadOidcProviders reads a JSON array of OidcProviderConfig from path.
+func loadOidcProviders(path string) ([]OidcProviderConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []OidcProviderConfig
+	if err := json.Unmarshal(raw, &providers); err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the prometheus collectors exposed by the API server.
+type Metrics struct {
+	namespace     string
+	AuthzRequests *prometheus.CounterVec
+	// AuthDecisions counts authentication/authorization outcomes, labeled by
+	// decision ("allow"/"deny") and reason code (e.g. "expired", "group_denied").
+	AuthDecisions *prometheus.CounterVec
+	// AuthLatency observes how long an authentication/authorization check
+	// took, labeled by decision.
+	AuthLatency *prometheus.HistogramVec
+	register    prometheus.Registerer
+}
+
+// NewMetrics creates and registers the API server metrics under the given namespace.
+// testMode skips registration against the default registry so unit tests can create
+// multiple instances without panicking on duplicate collectors.
+func NewMetrics(namespace string, testMode bool) *Metrics {
+	m := &Metrics{
+		namespace: namespace,
+		AuthzRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "authz_requests_total",
+			Help:      "Total number of authorization requests handled by the API server.",
+		}, []string{"status"}),
+		AuthDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_decisions_total",
+			Help:      "Total number of authentication/authorization decisions, by decision and reason.",
+		}, []string{"decision", "reason"}),
+		AuthLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "auth_latency_seconds",
+			Help:      "Time taken to authenticate/authorize a request, by decision.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"decision"}),
+	}
+
+	if testMode {
+		return m
+	}
+
+	m.register = prometheus.DefaultRegisterer
+	m.register.MustRegister(m.AuthzRequests, m.AuthDecisions, m.AuthLatency)
+
+	return m
+}